@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+	"github.com/NVIDIA/k8s-device-plugin/pkg/metrics"
+)
+
+// MetricsAddr is the address the Prometheus endpoint is served on, e.g.
+// ":9400". It is bound to the --metrics-addr flag; leaving it empty
+// disables metrics entirely.
+var MetricsAddr = ""
+
+// metricsCollectionPeriod is how often per-device NVML gauges are
+// refreshed.
+const metricsCollectionPeriod = 30 * time.Second
+
+var (
+	metricsOnce     sync.Once
+	metricsRegistry *metrics.Registry
+)
+
+// StartMetrics builds a metrics.Registry, starts its device collector
+// polling every device accepted by allDevices, and serves it on
+// MetricsAddr. It's called from NewMigStrategy, which is this plugin's
+// entry point before any strategy starts registering resources, so the
+// endpoint is live for the lifetime of the process. Subsequent calls are
+// no-ops: every MigStrategy shares the one registry. It is a no-op
+// altogether if MetricsAddr is empty, and a registry built that way
+// propagates as nil to every recordDevicesAdvertised/recordAllocation/
+// recordDeallocation call, which all tolerate it.
+func StartMetrics(allDevices func() []*nvml.Device) *metrics.Registry {
+	if MetricsAddr == "" {
+		return nil
+	}
+
+	metricsOnce.Do(func() {
+		registry := metrics.NewRegistry()
+
+		collector := metrics.NewCollector(registry, metricsCollectionPeriod, allDevices)
+		go collector.Start()
+
+		go func() {
+			check(metrics.Serve(MetricsAddr, registry))
+		}()
+
+		metricsRegistry = registry
+	})
+
+	return metricsRegistry
+}
+
+// recordDevicesAdvertised sets the number of devices currently advertised
+// for resource. registry may be nil when metrics are disabled, in which
+// case this is a no-op.
+func recordDevicesAdvertised(registry *metrics.Registry, resource string, n int) {
+	if registry == nil {
+		return
+	}
+	registry.DevicesAdvertised.WithLabelValues(resource).Set(float64(n))
+}
+
+// recordAllocation updates the allocation gauges and counters in registry
+// for a single device handed out to (namespace, pod, container) under
+// resource. registry may be nil when metrics are disabled, in which case
+// this is a no-op.
+func recordAllocation(registry *metrics.Registry, resource, namespace, pod, container string) {
+	if registry == nil {
+		return
+	}
+	registry.DevicesAllocated.WithLabelValues(resource).Inc()
+	registry.AllocationsTotal.WithLabelValues(resource, namespace, pod, container).Inc()
+}
+
+// recordDeallocation is recordAllocation's counterpart, called as a
+// container holding a device terminates.
+func recordDeallocation(registry *metrics.Registry, resource, namespace, pod, container string) {
+	if registry == nil {
+		return
+	}
+	registry.DevicesAllocated.WithLabelValues(resource).Dec()
+	registry.DeallocationsTotal.WithLabelValues(resource, namespace, pod, container).Inc()
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const (
+	MigDeviceIDIndex = "index"
+	MigDeviceIDUUID  = "uuid"
+)
+
+// MigDeviceIDStrategy selects how MIG device IDs are surfaced to kubelet:
+// "index" keeps the legacy {gpu}/{gi}/{ci} triple, "uuid" uses the NVML MIG
+// UUID instead, which survives a driver reload, MIG reconfiguration or
+// device reordering that would otherwise make kubelet think previously
+// allocated devices vanished. It is bound to the --mig-device-id flag and
+// defaults to "index" to preserve today's behavior.
+var MigDeviceIDStrategy = MigDeviceIDIndex
+
+// MigDeviceManager implements DeviceManager over the MIG devices that
+// match a single MigStrategy/resource pair (e.g. every mig-1g.5gb device
+// under the "mixed" strategy).
+type MigDeviceManager struct {
+	strategy MigStrategy
+	resource string
+
+	// byID maps the ID last handed to kubelet back to the MIG device's
+	// current NVML handle. Devices() rebuilds it wholesale on every call
+	// - which ListAndWatch makes on every NVML refresh, so a
+	// reconfiguration or driver reload is picked up on the next tick -
+	// while Allocate reads it from a concurrent goroutine servicing
+	// kubelet's gRPC calls. mu guards the map reference itself; Devices()
+	// builds the replacement map before taking the lock so the swap is
+	// the only work done while held.
+	mu   sync.RWMutex
+	byID map[string]*nvml.Device
+}
+
+// NewMigDeviceManager returns a MigDeviceManager that advertises the MIG
+// devices strategy matches to resource.
+func NewMigDeviceManager(strategy MigStrategy, resource string) *MigDeviceManager {
+	return &MigDeviceManager{
+		strategy: strategy,
+		resource: resource,
+		byID:     make(map[string]*nvml.Device),
+	}
+}
+
+func (m *MigDeviceManager) Devices() []*pluginapi.Device {
+	byID := make(map[string]*nvml.Device)
+
+	var devs []*pluginapi.Device
+	for _, mig := range getAllMigDevices() {
+		if !m.strategy.MatchesResource(mig, m.resource) {
+			continue
+		}
+
+		id, err := m.deviceID(mig)
+		check(err)
+
+		byID[id] = mig
+		devs = append(devs, &pluginapi.Device{
+			ID:     id,
+			Health: pluginapi.Healthy,
+		})
+	}
+
+	m.mu.Lock()
+	m.byID = byID
+	m.mu.Unlock()
+
+	recordDevicesAdvertised(metricsRegistry, m.resource, len(devs))
+
+	return devs
+}
+
+// deviceID returns the ID kubelet should see for mig, under the configured
+// MigDeviceIDStrategy.
+func (m *MigDeviceManager) deviceID(mig *nvml.Device) (string, error) {
+	switch MigDeviceIDStrategy {
+	case MigDeviceIDUUID:
+		return mig.UUID, nil
+	case MigDeviceIDIndex:
+		return indexTripleID(mig)
+	}
+	return "", fmt.Errorf("unknown mig device id strategy: %v", MigDeviceIDStrategy)
+}
+
+// indexTripleID reproduces the legacy {gpu index}/{gi}/{ci} ID, which
+// breaks the moment a driver reload or MIG reconfiguration renumbers
+// anything underneath it.
+func indexTripleID(mig *nvml.Device) (string, error) {
+	parent, err := mig.GetMigParentDeviceLite()
+	if err != nil {
+		return "", err
+	}
+
+	attr, err := mig.GetAttributes()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d/%d/%d", parent.Index, attr.GpuInstanceId, attr.ComputeInstanceId), nil
+}
+
+// Allocate translates the kubelet-supplied device IDs back to their
+// current NVML handles via the byID index Devices() last rebuilt. Unlike
+// an index-based lookup, this fails loudly instead of silently resolving
+// to whatever now happens to sit at that index: if an ID no longer exists
+// - because the device was reconfigured, reset, or reordered out from
+// under us - Allocate returns a clear error instead of handing back a
+// stale or wrong device.
+//
+// namespace, pod and container identify the caller for the
+// nvidia_gpu_allocations_total metric; NvidiaDevicePlugin.Allocate is
+// expected to pass through whatever it has for the container it's
+// currently servicing.
+func (m *MigDeviceManager) Allocate(ids []string, namespace, pod, container string) ([]*nvml.Device, error) {
+	m.mu.RLock()
+	byID := m.byID
+	m.mu.RUnlock()
+
+	devs := make([]*nvml.Device, 0, len(ids))
+	for _, id := range ids {
+		mig, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("mig device %v no longer exists (driver reload, MIG reconfiguration, or device reordering?)", id)
+		}
+		devs = append(devs, mig)
+		recordAllocation(metricsRegistry, m.resource, namespace, pod, container)
+	}
+	return devs, nil
+}
+
+// Release records that the container identified by namespace, pod and
+// container has given up the devices behind ids, decrementing the
+// allocation gauge and bumping the deallocation counter.
+// NvidiaDevicePlugin is expected to call this once it observes (e.g. via
+// a kubelet pod-resources watch) that the container has terminated, since
+// the v1beta1 device plugin API itself has no deallocation callback.
+func (m *MigDeviceManager) Release(ids []string, namespace, pod, container string) {
+	for range ids {
+		recordDeallocation(metricsRegistry, m.resource, namespace, pod, container)
+	}
+}
+
+// CheckHealth forwards NVML's health/Xid event stream for the matched MIG
+// devices.
+func (m *MigDeviceManager) CheckHealth(stop <-chan interface{}, devs []*pluginapi.Device, unhealthy chan<- *pluginapi.Device) {
+	checkHealth(stop, devs, unhealthy)
+}
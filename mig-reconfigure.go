@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/NVIDIA/k8s-device-plugin/pkg/mig/config"
+)
+
+// PluginRestarter drains whatever device plugin sockets are currently
+// serving resources and re-registers them, picking up a MigStrategy built
+// fresh from the MIG layout now on the node. main wires this to its own
+// plugin start/stop goroutines; ReconfigureMig treats a nil restarter as
+// "caller will restart the plugin itself" and just applies the MIG
+// profile.
+type PluginRestarter func() error
+
+// ReconfigureMig applies the MIG profile named by profileName, as read from
+// the config document at configPath. It is a no-op when profileName is
+// empty, so operators can keep relying on MIG geometry configured
+// out-of-band as before.
+//
+// Called before the device plugin sockets are ever started, restart should
+// be nil: the strategies in mig-strategy.go only enumerate MIG devices
+// that already exist, so they'll naturally pick up the new layout the
+// first time they run. Called to react to a live label/ConfigMap change
+// once the plugin is already serving resources under the old layout,
+// restart must be supplied so kubelet is told to re-discover whatever
+// resource names the new layout produces - a GPU instance's resource name
+// is derived from its shape, so a relayout can make old names vanish and
+// new ones appear.
+func ReconfigureMig(configPath, profileName string, restart PluginRestarter) error {
+	if profileName == "" {
+		return nil
+	}
+
+	cfg, err := config.ParseConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("error loading MIG config: %v", err)
+	}
+
+	specs, err := cfg.GetProfile(profileName)
+	if err != nil {
+		return err
+	}
+
+	applier := config.NewApplier()
+	for _, spec := range specs {
+		if err := applier.Apply(spec); err != nil {
+			return fmt.Errorf("error applying MIG profile %q: %v", profileName, err)
+		}
+	}
+
+	if restart == nil {
+		return nil
+	}
+
+	if err := restart(); err != nil {
+		return fmt.Errorf("error restarting device plugin after applying MIG profile %q: %v", profileName, err)
+	}
+
+	return nil
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
 	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
@@ -29,6 +30,7 @@ const (
 	MigStrategyMixed                      = "mixed"
 	MigStrategyMixedMemoryQualified       = "mixed-memory-qualified"
 	MigStrategyMixedFractionallyQualified = "mixed-fractionally-qualified"
+	MigStrategyShared                     = "shared"
 )
 
 type MigStrategyResourceSet map[string]struct{}
@@ -39,6 +41,8 @@ type MigStrategy interface {
 }
 
 func NewMigStrategy(strategy string) (MigStrategy, error) {
+	StartMetrics(allMonitorableDevices)
+
 	switch strategy {
 	case MigStrategyNone:
 		return &migStrategyNone{}, nil
@@ -50,6 +54,8 @@ func NewMigStrategy(strategy string) (MigStrategy, error) {
 		return &migStrategyMixedMemoryQualified{}, nil
 	case MigStrategyMixedFractionallyQualified:
 		return &migStrategyMixedFractionallyQualified{}, nil
+	case MigStrategyShared:
+		return newMigStrategyShared()
 	}
 	return nil, fmt.Errorf("Unknown strategy: %v", strategy)
 }
@@ -86,6 +92,36 @@ func getAllMigDevices() []*nvml.Device {
 	return migs
 }
 
+// allMonitorableDevices returns every device the metrics collector should
+// poll: MIG instances on GPUs that have MIG enabled, plus the plain GPUs
+// themselves when they don't, so gpu.shared and gpu-Ngib resources from
+// migStrategyShared get the same per-device gauges MIG resources do.
+func allMonitorableDevices() []*nvml.Device {
+	n, err := nvml.GetDeviceCount()
+	check(err)
+
+	var devs []*nvml.Device
+	for i := uint(0); i < n; i++ {
+		d, err := nvml.NewDeviceLite(i)
+		check(err)
+
+		migEnabled, err := d.IsMigEnabled()
+		check(err)
+
+		if !migEnabled {
+			devs = append(devs, d)
+			continue
+		}
+
+		migs, err := d.GetMigDevices()
+		check(err)
+
+		devs = append(devs, migs...)
+	}
+
+	return devs
+}
+
 // migStrategyNone
 func (s *migStrategyNone) GetPlugins() []*NvidiaDevicePlugin {
 	return []*NvidiaDevicePlugin{
@@ -230,23 +266,69 @@ func (s *migStrategyMixedFractionallyQualified) getResourceName(mig *nvml.Device
 	attr, err := mig.GetAttributes()
 	check(err)
 
-	// The following algorithm is customized knowing we have 7 slices on
-	// Ampere. It interprets a MigHalf as 3, MigQuarter as 2 and MigEighth
-	// as 1. On future GPUs we should have 8 maximum MIG devices, not 7, so
-	// this will need to change.
-	//
-	// TODO: generalize this for future architectures 8 or more slices.
-	if int(attr.GpuInstanceSliceCount) == ((maxMigs+1)/2)-1 {
-		return "mig-half"
+	return migFractionName(int(attr.GpuInstanceSliceCount), maxMigs)
+}
+
+// migFractionDenominators are the power-of-two fractions of a full GPU we
+// know how to name. They're tried in order against the nearest match, so a
+// future part with more than 16 MIG slices still degrades gracefully to the
+// mig-Ngth fallback below instead of panicking.
+var migFractionDenominators = []int{1, 2, 4, 8, 16}
+
+var migFractionNames = map[int]string{
+	1:  "mig-whole",
+	2:  "mig-half",
+	4:  "mig-quarter",
+	8:  "mig-eighth",
+	16: "mig-sixteenth",
+}
+
+// migFractionName maps a GPU instance's slice count, out of maxMigs total
+// slices available on its parent, to a resource name. maxMigs need not be a
+// power of two itself (e.g. it's 7 on Ampere): the fraction
+// f = sliceCount/maxMigs is matched against the nearest power-of-two
+// fraction in migFractionDenominators, allowing up to half a slice of
+// rounding slop. A sliceCount that isn't within that slop of any named
+// fraction (e.g. a third of maxMigs) falls back to a canonical "mig-Ngth"
+// string instead of panicking, so unanticipated layouts still get a stable,
+// matchable resource name.
+func migFractionName(sliceCount, maxMigs int) string {
+	if sliceCount <= 0 || maxMigs <= 0 {
+		panic("Unsupported MIG instance size")
 	}
-	if int(attr.GpuInstanceSliceCount) == ((maxMigs + 1) / 4) {
-		return "mig-quarter"
+
+	// |sliceCount/maxMigs - 1/d| is compared across candidate denominators
+	// as the integer diff = |sliceCount*d - maxMigs|, since minimizing
+	// diff/d minimizes the same distance without ever dividing. Candidates
+	// are compared against each other by cross-multiplication
+	// (diffA/dA < diffB/dB <=> diffA*dB < diffB*dA) to keep every
+	// comparison exact.
+	best := migFractionDenominators[0]
+	bestDiff := iabs(sliceCount*best - maxMigs)
+	for _, d := range migFractionDenominators[1:] {
+		diff := iabs(sliceCount*d - maxMigs)
+		if diff*best < bestDiff*d {
+			best, bestDiff = d, diff
+		}
 	}
-	if int(attr.GpuInstanceSliceCount) == ((maxMigs + 1) / 8) {
-		return "mig-eighth"
+
+	// The nearest candidate is a clean match when sliceCount/maxMigs is
+	// within half a slice's width of 1/best, i.e.
+	// |sliceCount/maxMigs - 1/best| <= 0.5/maxMigs, which rearranges to
+	// 2*bestDiff <= best with no rounding or division involved.
+	if 2*bestDiff <= best {
+		return migFractionNames[best]
 	}
 
-	panic("Unsupported MIG instance size")
+	return fmt.Sprintf("mig-%dgth", int(math.Round(float64(maxMigs)/float64(sliceCount))))
+}
+
+// iabs returns the absolute value of n.
+func iabs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
 }
 
 func (s *migStrategyMixedFractionallyQualified) MatchesResource(mig *nvml.Device, resource string) bool {
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "testing"
+
+// TestMigFractionName pins migFractionName against synthetic
+// GpuInstanceSliceCount/maxMigs pairs for the 7-slice Ampere layout as well
+// as hypothetical 8- and 16-slice parts, and a layout that isn't a clean
+// power-of-two fraction of maxMigs at all.
+//
+// migStrategyMixedFractionallyQualified.getResourceName (and so
+// MatchesResource, which is defined as getResourceName(mig) == resource)
+// is a thin wrapper that reads GpuInstanceSliceCount and maxMigs off a real
+// *nvml.Device and feeds them straight into migFractionName, so pinning
+// migFractionName's slice-count/maxMigs -> name mapping here is what
+// actually exercises the round-trip: every name it can produce is also a
+// name MatchesResource will recognize for that same (sliceCount, maxMigs)
+// pair, since both sides of the comparison go through this one function.
+func TestMigFractionName(t *testing.T) {
+	cases := []struct {
+		name       string
+		sliceCount int
+		maxMigs    int
+		want       string
+	}{
+		{"ampere-7-whole", 7, 7, "mig-whole"},
+		{"ampere-7-half", 3, 7, "mig-half"},
+		{"ampere-7-quarter", 2, 7, "mig-quarter"},
+		{"ampere-7-eighth", 1, 7, "mig-eighth"},
+
+		{"8-slice-whole", 8, 8, "mig-whole"},
+		{"8-slice-half", 4, 8, "mig-half"},
+		{"8-slice-quarter", 2, 8, "mig-quarter"},
+		{"8-slice-eighth", 1, 8, "mig-eighth"},
+
+		{"16-slice-whole", 16, 16, "mig-whole"},
+		{"16-slice-half", 8, 16, "mig-half"},
+		{"16-slice-quarter", 4, 16, "mig-quarter"},
+		{"16-slice-eighth", 2, 16, "mig-eighth"},
+		{"16-slice-sixteenth", 1, 16, "mig-sixteenth"},
+
+		// 3 slices out of 9 is a clean third, not within half a slice of
+		// any power-of-two fraction, so it falls back to the generic name.
+		{"non-power-of-two-fallback", 3, 9, "mig-3gth"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := migFractionName(c.sliceCount, c.maxMigs)
+			if got != c.want {
+				t.Errorf("migFractionName(%d, %d) = %q, want %q", c.sliceCount, c.maxMigs, got, c.want)
+			}
+
+			// MatchesResource is defined as getResourceName(mig) ==
+			// resource, i.e. it round-trips through this exact string:
+			// confirm no other slice count on the same maxMigs produces
+			// the same name, which would make MatchesResource match the
+			// wrong fraction. Different layouts share names on purpose
+			// (a half is "mig-half" on 7, 8 and 16-slice parts alike).
+			for _, other := range cases {
+				if other.name != c.name && other.maxMigs == c.maxMigs && other.want == got {
+					t.Errorf("migFractionName(%d, %d) = %q collides with %s's name", c.sliceCount, c.maxMigs, got, other.name)
+				}
+			}
+		})
+	}
+}
+
+// TestMigFractionNamePanicsOnZero documents that migFractionName treats a
+// zero or negative slice count or maxMigs as a programming error rather
+// than silently returning a meaningless name.
+func TestMigFractionNamePanicsOnZero(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for zero sliceCount")
+		}
+	}()
+	migFractionName(0, 7)
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+)
+
+// DeviceIdentity is how a device should be labeled in the per-device
+// gauges below: its own UUID, and - for a MIG device - the UUID of the
+// physical GPU it was carved out of. UUID is always the device's NVML UUID
+// (the MIG UUID for a MIG device), which already uniquely identifies it;
+// ParentUUID only adds the dimension dashboards group by. The
+// {gpu}/{gi}/{ci} index triple is never used as a label, and there is no
+// setting to switch to it.
+type DeviceIdentity struct {
+	UUID       string
+	ParentUUID string
+}
+
+// Collector polls NVML for every device in Devices on each tick of period
+// and records the result into Registry's per-device gauges.
+type Collector struct {
+	registry *Registry
+	devices  func() []*nvml.Device
+	period   time.Duration
+	stop     chan struct{}
+}
+
+// NewCollector builds a Collector that calls devices on every tick to get
+// the current device set, so MIG reconfiguration or a driver reload is
+// picked up without restarting the collector.
+func NewCollector(registry *Registry, period time.Duration, devices func() []*nvml.Device) *Collector {
+	return &Collector{
+		registry: registry,
+		devices:  devices,
+		period:   period,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start polls until Stop is called. It's meant to be run in its own
+// goroutine.
+func (c *Collector) Start() {
+	ticker := time.NewTicker(c.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.collect()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Stop ends the polling loop started by Start.
+func (c *Collector) Stop() {
+	close(c.stop)
+}
+
+func (c *Collector) collect() {
+	for _, d := range c.devices() {
+		identity := deviceIdentity(d)
+
+		status, err := d.Status()
+		if err != nil {
+			c.registry.NVMLErrorsTotal.WithLabelValues("Status").Inc()
+			continue
+		}
+
+		if status.Memory != nil {
+			c.registry.MemoryUsedBytes.WithLabelValues(identity.UUID, identity.ParentUUID).Set(float64(*status.Memory.Global) * 1024 * 1024)
+		}
+		if status.Utilization != nil && status.Utilization.GPU != nil {
+			c.registry.UtilizationSMRatio.WithLabelValues(identity.UUID, identity.ParentUUID).Set(float64(*status.Utilization.GPU) / 100)
+		}
+		if status.Temperature != nil {
+			c.registry.TemperatureCelsius.WithLabelValues(identity.UUID, identity.ParentUUID).Set(float64(*status.Temperature))
+		}
+		if status.Power != nil {
+			c.registry.PowerWatts.WithLabelValues(identity.UUID, identity.ParentUUID).Set(float64(*status.Power) / 1000)
+		}
+		if status.ECCErrors != nil && status.ECCErrors.Volatile != nil && status.ECCErrors.Volatile.DBE != nil {
+			c.registry.EccErrorsTotal.WithLabelValues(identity.UUID, identity.ParentUUID).Set(float64(*status.ECCErrors.Volatile.DBE.Total))
+		}
+
+		if d.Memory != nil {
+			c.registry.MemoryTotalBytes.WithLabelValues(identity.UUID, identity.ParentUUID).Set(float64(*d.Memory) * 1024 * 1024)
+		}
+	}
+}
+
+// deviceIdentity derives the UUID labels for d, tagging MIG devices with
+// their parent GPU's UUID so dashboards can aggregate by either dimension.
+func deviceIdentity(d *nvml.Device) DeviceIdentity {
+	parent, err := d.GetMigParentDeviceLite()
+	if err != nil {
+		// Not a MIG device (or MIG isn't supported on it): it's its own
+		// parent for labeling purposes.
+		return DeviceIdentity{UUID: d.UUID, ParentUUID: d.UUID}
+	}
+
+	return DeviceIdentity{UUID: d.UUID, ParentUUID: parent.UUID}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metrics exposes the device plugin and its MIG strategies as
+// Prometheus metrics, so a node's GPU and MIG inventory, allocation state
+// and per-device telemetry can be scraped without a separate DCGM exporter
+// sidecar.
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Labels shared by the per-device gauges below. parent_uuid equals uuid
+// for plain, non-MIG GPUs.
+const (
+	labelResource   = "resource"
+	labelUUID       = "uuid"
+	labelParentUUID = "parent_uuid"
+)
+
+// Registry bundles every metric this package produces behind a private
+// prometheus.Registerer, so callers can't accidentally register a metric
+// twice or leak the global default registry into tests.
+type Registry struct {
+	registry prometheus.Registerer
+	gatherer prometheus.Gatherer
+
+	DevicesAdvertised  *prometheus.GaugeVec
+	DevicesAllocated   *prometheus.GaugeVec
+	AllocationsTotal   *prometheus.CounterVec
+	DeallocationsTotal *prometheus.CounterVec
+	NVMLErrorsTotal    *prometheus.CounterVec
+
+	MemoryTotalBytes   *prometheus.GaugeVec
+	MemoryUsedBytes    *prometheus.GaugeVec
+	UtilizationSMRatio *prometheus.GaugeVec
+	TemperatureCelsius *prometheus.GaugeVec
+	PowerWatts         *prometheus.GaugeVec
+	EccErrorsTotal     *prometheus.GaugeVec
+}
+
+// NewRegistry builds and registers every metric in its own
+// prometheus.Registry, ready to be served by Serve.
+func NewRegistry() *Registry {
+	reg := prometheus.NewRegistry()
+
+	r := &Registry{
+		registry: reg,
+		gatherer: reg,
+
+		DevicesAdvertised: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "devices_advertised",
+			Help:      "Number of devices currently advertised to kubelet for a resource name.",
+		}, []string{labelResource}),
+
+		DevicesAllocated: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "devices_allocated",
+			Help:      "Number of devices currently allocated to running containers for a resource name.",
+		}, []string{labelResource}),
+
+		AllocationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "allocations_total",
+			Help:      "Total number of devices handed out via Allocate.",
+		}, []string{labelResource, "namespace", "pod", "container"}),
+
+		DeallocationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "deallocations_total",
+			Help:      "Total number of devices released as their container terminated.",
+		}, []string{labelResource, "namespace", "pod", "container"}),
+
+		NVMLErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "nvml_errors_total",
+			Help:      "Total number of NVML calls that returned an error, by call name.",
+		}, []string{"call"}),
+
+		MemoryTotalBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "memory_total_bytes",
+			Help:      "Total memory of a device, in bytes.",
+		}, []string{labelUUID, labelParentUUID}),
+
+		MemoryUsedBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "memory_used_bytes",
+			Help:      "Used memory of a device, in bytes.",
+		}, []string{labelUUID, labelParentUUID}),
+
+		UtilizationSMRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "utilization_sm_ratio",
+			Help:      "Streaming multiprocessor utilization of a device, in the range [0,1].",
+		}, []string{labelUUID, labelParentUUID}),
+
+		TemperatureCelsius: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "temperature_celsius",
+			Help:      "Current temperature of a device, in degrees Celsius.",
+		}, []string{labelUUID, labelParentUUID}),
+
+		PowerWatts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "power_watts",
+			Help:      "Current power draw of a device, in watts.",
+		}, []string{labelUUID, labelParentUUID}),
+
+		EccErrorsTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nvidia_gpu",
+			Name:      "ecc_errors_total",
+			Help:      "Cumulative double-bit ECC errors reported by a device.",
+		}, []string{labelUUID, labelParentUUID}),
+	}
+
+	reg.MustRegister(
+		r.DevicesAdvertised,
+		r.DevicesAllocated,
+		r.AllocationsTotal,
+		r.DeallocationsTotal,
+		r.NVMLErrorsTotal,
+		r.MemoryTotalBytes,
+		r.MemoryUsedBytes,
+		r.UtilizationSMRatio,
+		r.TemperatureCelsius,
+		r.PowerWatts,
+		r.EccErrorsTotal,
+	)
+
+	return r
+}
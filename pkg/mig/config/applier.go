@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+)
+
+// Applier destroys the current GPU/compute instance layout on selected
+// GPUs and recreates it according to a MigConfigSpec.
+type Applier struct{}
+
+// NewApplier returns an Applier ready to reconfigure MIG geometry via NVML.
+func NewApplier() *Applier {
+	return &Applier{}
+}
+
+// Apply destroys any existing compute and GPU instances on every GPU
+// selected by spec, flips MIG mode on or off as spec.MigEnabled requires,
+// and recreates instances according to spec.MigDevices. It refuses to
+// touch a GPU that's currently in use - whether that means running
+// processes on existing MIG devices, or, when MIG mode itself is
+// currently disabled, a running compute context on the plain GPU that
+// enabling MIG would have to tear down - returning a descriptive error
+// rather than disrupting a running workload.
+//
+// Toggling MIG mode only takes effect after a GPU reset, which NVML
+// reports back as a pending change rather than performing itself. Since
+// resetting a GPU out from under a node is not something this package
+// will do unasked, Apply surfaces that as a clear, actionable error
+// instead of silently leaving the GPU in its old mode.
+func (a *Applier) Apply(spec MigConfigSpec) error {
+	for _, i := range spec.Devices {
+		d, err := nvml.NewDeviceLite(uint(i))
+		if err != nil {
+			return fmt.Errorf("error opening GPU %d: %v", i, err)
+		}
+
+		migEnabled, err := d.IsMigEnabled()
+		if err != nil {
+			return fmt.Errorf("error reading MIG mode on GPU %d: %v", i, err)
+		}
+
+		inUse, err := a.inUse(d, migEnabled)
+		if err != nil {
+			return fmt.Errorf("error checking GPU %d usage: %v", i, err)
+		}
+		if inUse {
+			return fmt.Errorf("refusing to reconfigure GPU %d: it has running compute contexts", i)
+		}
+
+		if migEnabled {
+			if err := a.destroyInstances(d); err != nil {
+				return fmt.Errorf("error destroying existing MIG instances on GPU %d: %v", i, err)
+			}
+		}
+
+		if migEnabled != spec.MigEnabled {
+			pendingReset, err := d.SetMigMode(spec.MigEnabled)
+			if err != nil {
+				return fmt.Errorf("error setting MIG mode to %v on GPU %d: %v", spec.MigEnabled, i, err)
+			}
+			if pendingReset {
+				return fmt.Errorf("GPU %d requires a GPU reset to finish switching MIG mode to %v; reset it out of band and re-apply this profile", i, spec.MigEnabled)
+			}
+		}
+
+		if !spec.MigEnabled {
+			continue
+		}
+
+		if err := a.createInstances(d, spec.MigDevices); err != nil {
+			return fmt.Errorf("error creating MIG instances on GPU %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// inUse reports whether GPU d is currently in use in a way that makes
+// reconfiguring it unsafe. When MIG mode is already enabled, that means
+// any running process on one of its existing MIG devices, since
+// destroying GPU/compute instances underneath a live context needs a GPU
+// reset the plugin cannot safely perform on the operator's behalf. When
+// MIG mode is disabled, GetMigDevices has nothing to report, so this
+// checks the plain GPU itself instead: enabling MIG also requires a reset,
+// which would just as surely disrupt anything running on it today.
+func (a *Applier) inUse(d *nvml.Device, migEnabled bool) (bool, error) {
+	if !migEnabled {
+		procs, err := d.GetAllRunningProcesses()
+		if err != nil {
+			return false, err
+		}
+		return len(procs) > 0, nil
+	}
+
+	migs, err := d.GetMigDevices()
+	if err != nil {
+		return false, err
+	}
+
+	for _, mig := range migs {
+		procs, err := mig.GetAllRunningProcesses()
+		if err != nil {
+			return false, err
+		}
+		if len(procs) > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// destroyInstances tears down every compute instance before its parent GPU
+// instance, since NVML refuses to destroy a GPU instance that still has
+// live compute instances.
+func (a *Applier) destroyInstances(d *nvml.Device) error {
+	gis, err := d.GetGpuInstances()
+	if err != nil {
+		return err
+	}
+
+	for _, gi := range gis {
+		cis, err := gi.GetComputeInstances()
+		if err != nil {
+			return err
+		}
+		for _, ci := range cis {
+			if err := ci.Destroy(); err != nil {
+				return err
+			}
+		}
+		if err := gi.Destroy(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createInstances creates the GPU instance and a matching compute instance
+// for each profile in devices, where devices maps a profile name (e.g.
+// "1g.5gb") to how many copies of it to create.
+func (a *Applier) createInstances(d *nvml.Device, devices map[string]int) error {
+	for name, count := range devices {
+		giSlices, ciSlices, err := parseProfileName(name)
+		if err != nil {
+			return err
+		}
+
+		for n := 0; n < count; n++ {
+			gi, err := d.CreateGpuInstanceBySliceCount(giSlices)
+			if err != nil {
+				return fmt.Errorf("error creating gpu instance %q: %v", name, err)
+			}
+			if _, err := gi.CreateComputeInstanceBySliceCount(ciSlices); err != nil {
+				return fmt.Errorf("error creating compute instance %q: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package config loads named MIG partitioning profiles and applies them to
+// a node's GPUs before the device plugin starts registering resources. A
+// profile is selected by name (e.g. via the "nvidia.com/mig.config" node
+// label) and maps to a desired layout of GPU-instance/compute-instance
+// slices per GPU.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// MigConfigSpec describes the desired MIG layout for a set of GPUs. Devices
+// lists the GPU indices the spec applies to; MigDevices maps a profile name
+// (e.g. "1g.5gb", "3g.20gb") to how many instances of that profile to
+// create on each of those GPUs.
+type MigConfigSpec struct {
+	Devices    []int          `yaml:"devices"`
+	MigEnabled bool           `yaml:"mig-enabled"`
+	MigDevices map[string]int `yaml:"mig-devices"`
+}
+
+// Config is the top-level document mapping a profile name to the specs
+// that make it up.
+type Config struct {
+	Version    string                     `yaml:"version"`
+	MigConfigs map[string][]MigConfigSpec `yaml:"mig-configs"`
+}
+
+// ParseConfig reads and parses the YAML document at path.
+func ParseConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	return &c, nil
+}
+
+// GetProfile returns the specs that make up the named profile.
+func (c *Config) GetProfile(name string) ([]MigConfigSpec, error) {
+	specs, ok := c.MigConfigs[name]
+	if !ok {
+		return nil, fmt.Errorf("no such mig profile: %v", name)
+	}
+	return specs, nil
+}
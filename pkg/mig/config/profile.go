@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var profileNamePattern = regexp.MustCompile(`^(?:(\d+)c\.)?(\d+)g\.(\d+)gb$`)
+
+// parseProfileName splits a profile name such as "3g.20gb" or "1c.3g.20gb"
+// into its compute-instance and GPU-instance slice counts, mirroring the
+// "mig-%dc.%dg.%dgb" / "mig-%dg.%dgb" formats produced by migStrategySingle
+// and migStrategyMixed. When the compute-instance count is omitted it
+// defaults to the GPU-instance count, matching non-sliced MIG profiles.
+func parseProfileName(name string) (giSliceCount int, ciSliceCount int, err error) {
+	m := profileNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0, 0, fmt.Errorf("malformed mig profile name: %v", name)
+	}
+
+	g, err := strconv.Atoi(m[2])
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed mig profile name: %v", name)
+	}
+
+	if m[1] == "" {
+		return g, g, nil
+	}
+
+	c, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed mig profile name: %v", name)
+	}
+
+	return g, c, nil
+}
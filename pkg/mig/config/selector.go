@@ -0,0 +1,32 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import "strings"
+
+// MigConfigLabel is the node label used to select a MIG configuration
+// profile by name, e.g. "nvidia.com/mig.config=all-1g.5gb". It is also the
+// key operators are expected to use when driving selection from a
+// ConfigMap instead.
+const MigConfigLabel = "nvidia.com/mig.config"
+
+// ProfileFromLabels returns the profile name requested via MigConfigLabel,
+// and whether the label was present at all.
+func ProfileFromLabels(labels map[string]string) (string, bool) {
+	name, ok := labels[MigConfigLabel]
+	return strings.TrimSpace(name), ok
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// SharedDeviceManager implements DeviceManager for a single resource
+// produced by migStrategyShared (e.g. "gpu.shared" or one specific
+// memory-bucket resource like "gpu-2gib"). Unlike GpuDeviceManager and
+// MigDeviceManager, the IDs it advertises don't map one-to-one to a
+// physical device: each physical GPU is listed once per logical replica
+// it's been split into, so kubelet can hand the same underlying UUID out
+// to several containers at once. Allocate additionally returns the CUDA
+// MPS environment a container needs to stay within its share of the GPU,
+// which NvidiaDevicePlugin folds into the ContainerAllocateResponse
+// alongside the NVIDIA_VISIBLE_DEVICES env var every DeviceManager gets.
+type SharedDeviceManager struct {
+	strategy *migStrategyShared
+	resource string
+}
+
+// NewSharedDeviceManager returns a DeviceManager that advertises every
+// physical GPU as the logical replicas of resource described by strategy.
+func NewSharedDeviceManager(strategy *migStrategyShared, resource string) *SharedDeviceManager {
+	return &SharedDeviceManager{strategy: strategy, resource: resource}
+}
+
+// replicaID is the ID kubelet sees for one logical slot of a physical GPU:
+// the GPU's UUID plus the replica index, so Allocate can recover both.
+func replicaID(uuid string, replica int) string {
+	return fmt.Sprintf("%s%s%d", uuid, replicaIDSeparator, replica)
+}
+
+func (m *SharedDeviceManager) Devices() []*pluginapi.Device {
+	n, err := nvml.GetDeviceCount()
+	check(err)
+
+	var devs []*pluginapi.Device
+	for i := uint(0); i < n; i++ {
+		d, err := nvml.NewDeviceLite(i)
+		check(err)
+
+		migEnabled, err := d.IsMigEnabled()
+		check(err)
+		if migEnabled {
+			// MIG-enabled GPUs are handled by MigDeviceManager; sharing
+			// applies to cards run as plain, undivided GPUs.
+			continue
+		}
+
+		replicas := m.strategy.replicasPerDevice(m.resource)
+		for r := 0; r < replicas; r++ {
+			devs = append(devs, &pluginapi.Device{
+				ID:     replicaID(d.UUID, r),
+				Health: pluginapi.Healthy,
+			})
+		}
+	}
+
+	recordDevicesAdvertised(metricsRegistry, m.resource, len(devs))
+
+	return devs
+}
+
+// CheckHealth forwards NVML's health/Xid event stream for the underlying
+// physical GPUs to every replica ID derived from them, since a replica is
+// only as healthy as the card it shares.
+func (m *SharedDeviceManager) CheckHealth(stop <-chan interface{}, devs []*pluginapi.Device, unhealthy chan<- *pluginapi.Device) {
+	checkHealth(stop, devs, unhealthy)
+}
+
+// Allocate translates the kubelet-supplied replica IDs back to the NVML
+// handles of the physical GPUs behind them, and builds the
+// NVIDIA_VISIBLE_DEVICES plus CUDA MPS environment a container sharing
+// those GPUs needs. namespace, pod and container identify the caller for
+// the nvidia_gpu_allocations_total metric, mirroring
+// MigDeviceManager.Allocate.
+func (m *SharedDeviceManager) Allocate(ids []string, namespace, pod, container string) ([]*nvml.Device, map[string]string, error) {
+	uuids := make([]string, 0, len(ids))
+	devs := make([]*nvml.Device, 0, len(ids))
+	for _, id := range ids {
+		uuid, _, err := splitReplicaID(id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		d, err := deviceByUUID(uuid)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		uuids = append(uuids, uuid)
+		devs = append(devs, d)
+		recordAllocation(metricsRegistry, m.resource, namespace, pod, container)
+	}
+
+	env := m.allocateEnv(uuids)
+
+	return devs, env, nil
+}
+
+// Release records that the container identified by namespace, pod and
+// container has given up the replicas behind ids, mirroring
+// MigDeviceManager.Release. NvidiaDevicePlugin is expected to call this
+// once it observes the container has terminated, since the v1beta1
+// device plugin API has no deallocation callback of its own.
+func (m *SharedDeviceManager) Release(ids []string, namespace, pod, container string) {
+	for range ids {
+		recordDeallocation(metricsRegistry, m.resource, namespace, pod, container)
+	}
+}
+
+// allocateEnv builds the NVIDIA_VISIBLE_DEVICES plus CUDA MPS environment
+// that bounds a container to its share of the physical GPUs behind uuids.
+func (m *SharedDeviceManager) allocateEnv(uuids []string) map[string]string {
+	env := map[string]string{
+		"NVIDIA_VISIBLE_DEVICES": joinUnique(uuids),
+	}
+
+	if SharingMode != SharingModeMPS {
+		return env
+	}
+
+	replicas := m.strategy.replicasPerDevice(m.resource)
+	if replicas == 0 {
+		replicas = 1
+	}
+
+	env["CUDA_MPS_ACTIVE_THREAD_PERCENTAGE"] = fmt.Sprintf("%d", 100/replicas)
+	if mb, ok := m.strategy.bucketForResource(m.resource); ok {
+		env["CUDA_MPS_PINNED_DEVICE_MEM_LIMIT"] = fmt.Sprintf("%dMB", mb)
+	}
+
+	return env
+}
+
+// deviceByUUID looks up the physical GPU with the given NVML UUID.
+func deviceByUUID(uuid string) (*nvml.Device, error) {
+	n, err := nvml.GetDeviceCount()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint(0); i < n; i++ {
+		d, err := nvml.NewDeviceLite(i)
+		if err != nil {
+			return nil, err
+		}
+		if d.UUID == uuid {
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no GPU with UUID %v (driver reload or device reordering?)", uuid)
+}
+
+// replicaIDSeparator joins a physical GPU's UUID to the replica index in
+// the ID handed out by Devices(). NVML UUIDs are of the form
+// "GPU-<hex>-<hex>-..." and never contain it, so splitting on its last
+// occurrence is unambiguous.
+const replicaIDSeparator = "-rep-"
+
+// splitReplicaID recovers the physical GPU UUID and replica index encoded
+// by replicaID. It uses strings.LastIndex rather than fmt.Sscanf("%s..."):
+// Sscanf's %s is greedy and consumes the whole string (including the
+// "-rep-N" suffix) before the literal part of the format ever gets a
+// chance to match, so it fails on every ID this package itself generates.
+func splitReplicaID(id string) (uuid string, replica int, err error) {
+	i := strings.LastIndex(id, replicaIDSeparator)
+	if i < 0 {
+		return "", 0, fmt.Errorf("malformed shared device id: %v", id)
+	}
+
+	rep, err := strconv.Atoi(id[i+len(replicaIDSeparator):])
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed shared device id: %v", id)
+	}
+
+	return id[:i], rep, nil
+}
+
+// joinUnique returns the unique, comma-separated values of ids in their
+// first-seen order, collapsing the repeats caused by several replicas
+// resolving to the same physical GPU.
+func joinUnique(ids []string) string {
+	seen := make(map[string]struct{}, len(ids))
+	var out string
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		if out != "" {
+			out += ","
+		}
+		out += id
+	}
+	return out
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2020, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// SharingModeMPS splits a GPU between containers using CUDA MPS, so
+	// each container gets a hard thread/memory cap enforced by the MPS
+	// control daemon.
+	SharingModeMPS = "mps"
+	// SharingModeTimeSlice splits a GPU between containers by letting the
+	// driver's default time-slicing scheduler interleave their kernels;
+	// no per-container limits are enforced.
+	SharingModeTimeSlice = "timeslice"
+)
+
+// SharingMode selects how containers that are handed the same underlying
+// GPU actually share it. It is bound to the --sharing-mode flag and only
+// consulted when MigStrategyShared is in effect.
+var SharingMode = SharingModeTimeSlice
+
+// SharingReplicas is the fixed number of logical resources to advertise per
+// physical GPU under MigStrategyShared. It is bound to the
+// --shared-replicas flag and ignored when SharingMemoryBucketsMiB is set.
+var SharingReplicas = 0
+
+// SharingMemoryBucketsMiB splits each physical GPU into logical resources
+// named after a memory allotment (nvidia.com/gpu-1gib, nvidia.com/gpu-2gib,
+// ...) instead of a fixed replica count. It is bound to the
+// --shared-memory-buckets flag and takes precedence over SharingReplicas
+// when non-empty.
+var SharingMemoryBucketsMiB []int
+
+// migStrategyShared advertises each physical, non-MIG GPU as multiple
+// logical resources so that workloads that don't need a whole card can
+// share one, relying on CUDA MPS or the driver's time-slicing scheduler
+// rather than MIG partitioning. It implements MigStrategy so it can be
+// selected alongside the MIG strategies above, even though it never deals
+// with MIG devices itself.
+type migStrategyShared struct {
+	replicas      int
+	memoryBuckets []int
+}
+
+// newMigStrategyShared builds a migStrategyShared from the package-level
+// sharing flags, preferring memory buckets over a flat replica count when
+// both are set.
+func newMigStrategyShared() (MigStrategy, error) {
+	if SharingMode != SharingModeMPS && SharingMode != SharingModeTimeSlice {
+		return nil, fmt.Errorf("unknown sharing mode: %v", SharingMode)
+	}
+
+	if len(SharingMemoryBucketsMiB) > 0 {
+		return &migStrategyShared{memoryBuckets: SharingMemoryBucketsMiB}, nil
+	}
+
+	if SharingReplicas < 2 {
+		return nil, fmt.Errorf("shared strategy requires --shared-replicas >= 2 or --shared-memory-buckets")
+	}
+
+	return &migStrategyShared{replicas: SharingReplicas}, nil
+}
+
+func (s *migStrategyShared) GetPlugins() []*NvidiaDevicePlugin {
+	resources := s.resourceNames()
+
+	var plugins []*NvidiaDevicePlugin
+	for _, resource := range resources {
+		plugins = append(plugins, NewNvidiaDevicePlugin(
+			"nvidia.com/"+resource,
+			NewSharedDeviceManager(s, resource),
+			"NVIDIA_VISIBLE_DEVICES",
+			pluginapi.DevicePluginPath+"nvidia-"+resource+".sock"))
+	}
+
+	return plugins
+}
+
+func (s *migStrategyShared) MatchesResource(mig *nvml.Device, resource string) bool {
+	for _, r := range s.resourceNames() {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceNames lists the logical resource names this strategy advertises,
+// either one per memory bucket (gpu-1gib, gpu-2gib, gpu-1536mib, ...) or a
+// single replica-counted resource (gpu.shared).
+func (s *migStrategyShared) resourceNames() []string {
+	if len(s.memoryBuckets) > 0 {
+		names := make([]string, len(s.memoryBuckets))
+		for i, mb := range s.memoryBuckets {
+			names[i] = bucketResourceName(mb)
+		}
+		return names
+	}
+	return []string{"gpu.shared"}
+}
+
+// bucketResourceName names a memory-bucket resource. Buckets that are a
+// whole number of GiB get the friendly "gpu-Ngib" form from the request;
+// anything else is named in MiB so that, say, 1024 and 1536 don't both
+// round down to "gpu-1gib" and collide.
+func bucketResourceName(mb int) string {
+	if mb > 0 && mb%1024 == 0 {
+		return fmt.Sprintf("gpu-%dgib", mb/1024)
+	}
+	return fmt.Sprintf("gpu-%dmib", mb)
+}
+
+// bucketForResource returns the memory bucket, in MiB, that backs the
+// given resource name, and whether resource is one of this strategy's
+// memory-bucket resources at all (as opposed to the replica-counted
+// "gpu.shared").
+func (s *migStrategyShared) bucketForResource(resource string) (int, bool) {
+	for _, mb := range s.memoryBuckets {
+		if bucketResourceName(mb) == resource {
+			return mb, true
+		}
+	}
+	return 0, false
+}
+
+// replicasPerDevice returns how many logical slots each physical GPU
+// contributes to the given resource: one per memory-bucket resource, or
+// the fixed replica factor for the single replica-counted resource.
+func (s *migStrategyShared) replicasPerDevice(resource string) int {
+	if _, ok := s.bucketForResource(resource); ok {
+		return 1
+	}
+	return s.replicas
+}